@@ -0,0 +1,153 @@
+// Copyright 2020 Jonas Dahlbæk
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// tarMagicOffset and tarMagic are where archive/tar itself expects to find
+// the POSIX ustar magic, which is what Args.Untar auto-detection peeks for
+// in the decompressed stream.
+const tarMagicOffset = 257
+
+var tarMagic = []byte("ustar")
+
+// looksLikeTar peeks into r for the ustar magic without consuming any
+// bytes, so the caller can fall back to copying r verbatim if it doesn't
+// match.
+func looksLikeTar(r *bufio.Reader) bool {
+	head, err := r.Peek(tarMagicOffset + len(tarMagic))
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(head[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic)
+}
+
+// untarTo iterates the tar entries in r, emitting each member that matches
+// memberPattern (all of them, if memberPattern is nil). When dest is
+// stdout it opens dest itself (the only case needing a single shared
+// writer) and prints the same "---------- content of ... ----------"
+// banner as logContent, keyed by "s3://bucket/key!member" and gated by
+// keys; otherwise it writes each member under its own path via
+// dest.writeMember, preserving relative path, mode bits and mtime. dest is
+// never opened for the archive's own key - only for stdout, which has no
+// file/object to spuriously create.
+func untarTo(bucket, key string, r io.Reader, keys bool, dest Destination, obj Object, globFreePrefix string, memberPattern glob.Glob) {
+	var output io.Writer
+	if dest.stdout {
+		var closeOutput func() error
+		output, closeOutput = dest.open(obj, globFreePrefix)
+		defer func() {
+			if err := closeOutput(); err != nil {
+				log.Fatalf("failed to finish writing s3://%s/%s: %v", bucket, key, err)
+			}
+		}()
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Fatalf("failed while reading tar entries of s3://%s/%s: %v", bucket, key, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if memberPattern != nil && !memberPattern.Match(header.Name) {
+			continue
+		}
+
+		if dest.stdout {
+			if keys {
+				if _, err := fmt.Fprintf(output, "---------- content of s3://%s/%s!%s ----------\n", bucket, key, header.Name); err != nil {
+					log.Fatalf("failed while writing s3://%s/%s!%s banner: %v", bucket, key, header.Name, err)
+				}
+			}
+			if _, err := io.Copy(output, tr); err != nil {
+				log.Fatalf("failed while copying s3://%s/%s!%s: %v", bucket, key, header.Name, err)
+			}
+			continue
+		}
+
+		if err := dest.writeMember(obj, globFreePrefix, header, tr); err != nil {
+			log.Fatalf("failed while writing s3://%s/%s!%s to destination: %v", bucket, key, header.Name, err)
+		}
+	}
+}
+
+// archiveMemberPath mirrors obj's relative path under globFreePrefix, with
+// its own archive extension stripped, and appends the member's own path
+// underneath it.
+func archiveMemberPath(obj Object, globFreePrefix string, memberName string) string {
+	archiveRel := relativeKey(obj.Key, globFreePrefix)
+	for _, ext := range []string{".tar.gz", ".tar.zst", ".tar"} {
+		if strings.HasSuffix(archiveRel, ext) {
+			archiveRel = strings.TrimSuffix(archiveRel, ext)
+			break
+		}
+	}
+	return archiveRel + "/" + memberName
+}
+
+// writeMember writes a single tar member under d. Mode bits and mtime are
+// preserved for local destinations; S3 has no equivalent so they're
+// dropped there.
+func (d Destination) writeMember(obj Object, globFreePrefix string, header *tar.Header, body io.Reader) error {
+	rel := archiveMemberPath(obj, globFreePrefix, header.Name)
+
+	switch {
+	case d.s3Bucket != "":
+		key := strings.TrimPrefix(strings.TrimSuffix(d.s3Prefix, "/")+"/"+rel, "/")
+		pw, uploadDone := s3PipeUpload(d.s3Client, d.s3Bucket, key)
+		_, copyErr := io.Copy(pw, body)
+		pw.CloseWithError(copyErr)
+		if uploadErr := <-uploadDone; uploadErr != nil {
+			return uploadErr
+		}
+		return copyErr
+
+	default:
+		path := filepath.Join(d.localDir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode))
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, body); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		return os.Chtimes(path, header.ModTime, header.ModTime)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 Jonas Dahlbæk
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func bufReader(b []byte) *bufio.Reader {
+	return bufio.NewReader(bytes.NewReader(b))
+}
+
+func Test_archiveMemberPath(t *testing.T) {
+	tests := []struct {
+		name           string
+		key            string
+		globFreePrefix string
+		memberName     string
+		want           string
+	}{
+		{"tar.gz suffix stripped", "logs/2020/day.tar.gz", "logs/2020/", "a/b.json", "day/a/b.json"},
+		{"tar.zst suffix stripped", "logs/2020/day.tar.zst", "logs/2020/", "a/b.json", "day/a/b.json"},
+		{"plain tar suffix stripped", "logs/2020/day.tar", "logs/2020/", "a/b.json", "day/a/b.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := Object{Bucket: "bucket", Key: tt.key}
+			if got := archiveMemberPath(obj, tt.globFreePrefix, tt.memberName); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_looksLikeTar(t *testing.T) {
+	header := make([]byte, tarMagicOffset+len(tarMagic))
+	copy(header[tarMagicOffset:], tarMagic)
+
+	if !looksLikeTar(bufReader(header)) {
+		t.Error("expected ustar magic to be detected")
+	}
+	if looksLikeTar(bufReader(make([]byte, tarMagicOffset+len(tarMagic)))) {
+		t.Error("expected zeroed bytes not to be detected as tar")
+	}
+}
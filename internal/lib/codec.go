@@ -0,0 +1,201 @@
+// Copyright 2020 Jonas Dahlbæk
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+// Codec decompresses the body of an S3 object. Implementations are
+// stateless and safe to keep around as package-level values; NewReader is
+// expected to allocate a fresh decoder per call.
+type Codec interface {
+	Name() string
+	Magic() []byte
+	NewReader(io.Reader) (io.ReadCloser, error)
+}
+
+// codecs holds the built-in codecs in detection order. RegisterCodec
+// appends to it, so a caller's own codec is only consulted after these.
+var codecs = []Codec{
+	gzipCodec{},
+	zstdCodec{},
+	bzip2Codec{},
+	xzCodec{},
+	lz4Codec{},
+	snappyCodec{},
+	brotliCodec{},
+}
+
+// RegisterCodec adds a codec to the registry consulted by DetectCodec and
+// DetectCodecByMetadata. It is not safe to call concurrently with
+// logContent.
+func RegisterCodec(c Codec) {
+	codecs = append(codecs, c)
+}
+
+// maxMagicLen returns the longest magic among the registered codecs, used
+// to size logContent's peek buffer.
+func maxMagicLen() int {
+	max := 0
+	for _, c := range codecs {
+		if n := len(c.Magic()); n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+// DetectCodec matches head, the first maxMagicLen() bytes of an object,
+// against each registered codec's magic. Codecs with no magic (brotli has
+// none worth trusting) are never matched here, since attempting to open a
+// decoder over arbitrary bytes as a way to "sniff" them produces false
+// positives on ordinary data (e.g. long runs of a repeated byte) often
+// enough to silently corrupt output; they're only reachable via
+// DetectCodecByMetadata, an explicit signal from whoever wrote the object.
+func DetectCodec(head []byte) (Codec, bool) {
+	for _, c := range codecs {
+		magic := c.Magic()
+		if len(magic) > 0 && len(head) >= len(magic) && bytes.Equal(head[:len(magic)], magic) {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// DetectCodecByMetadata maps an S3 object's ContentEncoding/ContentType,
+// as reported by GetObject or HeadObject, to a registered codec. It is
+// tried before magic sniffing, since it's an explicit signal from whoever
+// wrote the object.
+func DetectCodecByMetadata(contentEncoding, contentType string) (Codec, bool) {
+	if name, ok := codecNamesByContentEncoding[strings.ToLower(contentEncoding)]; ok {
+		return codecByName(name)
+	}
+	if name, ok := codecNamesByContentType[strings.ToLower(contentType)]; ok {
+		return codecByName(name)
+	}
+	return nil, false
+}
+
+func codecByName(name string) (Codec, bool) {
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+var codecNamesByContentEncoding = map[string]string{
+	"gzip":            "gzip",
+	"x-gzip":          "gzip",
+	"zstd":            "zstd",
+	"br":              "brotli",
+	"bzip2":           "bzip2",
+	"x-bzip2":         "bzip2",
+	"xz":              "xz",
+	"x-xz":            "xz",
+	"lz4":             "lz4",
+	"x-lz4":           "lz4",
+	"x-snappy-framed": "snappy",
+}
+
+var codecNamesByContentType = map[string]string{
+	"application/gzip":            "gzip",
+	"application/x-gzip":          "gzip",
+	"application/zstd":            "zstd",
+	"application/x-zstd":          "zstd",
+	"application/x-bzip2":         "bzip2",
+	"application/x-xz":            "xz",
+	"application/x-lz4":           "lz4",
+	"application/x-snappy-framed": "snappy",
+	"application/x-brotli":        "brotli",
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string                                 { return "gzip" }
+func (gzipCodec) Magic() []byte                                { return []byte{0x1f, 0x8b, 0x08} }
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) }
+
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string  { return "zstd" }
+func (zstdCodec) Magic() []byte { return []byte{0x28, 0xb5, 0x2f, 0xfd} }
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return dec.IOReadCloser(), nil
+}
+
+type bzip2Codec struct{}
+
+func (bzip2Codec) Name() string  { return "bzip2" }
+func (bzip2Codec) Magic() []byte { return []byte{0x42, 0x5a, 0x68} } // "BZh"
+func (bzip2Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(bzip2.NewReader(r)), nil
+}
+
+type xzCodec struct{}
+
+func (xzCodec) Name() string  { return "xz" }
+func (xzCodec) Magic() []byte { return []byte{0xfd, '7', 'z', 'X', 'Z', 0x00} }
+func (xzCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	xr, err := xz.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(xr), nil
+}
+
+type lz4Codec struct{}
+
+func (lz4Codec) Name() string  { return "lz4" }
+func (lz4Codec) Magic() []byte { return []byte{0x04, 0x22, 0x4d, 0x18} }
+func (lz4Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(lz4.NewReader(r)), nil
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) Name() string { return "snappy" }
+func (snappyCodec) Magic() []byte {
+	return []byte{0xff, 0x06, 0x00, 0x00, 's', 'N', 'a', 'P', 'p', 'Y'}
+}
+func (snappyCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(snappy.NewReader(r)), nil
+}
+
+// brotliCodec has no magic worth trusting, so DetectCodec never matches it;
+// it's only reachable via DetectCodecByMetadata.
+type brotliCodec struct{}
+
+func (brotliCodec) Name() string  { return "brotli" }
+func (brotliCodec) Magic() []byte { return nil }
+func (brotliCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(brotli.NewReader(r)), nil
+}
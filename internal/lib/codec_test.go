@@ -0,0 +1,97 @@
+// Copyright 2020 Jonas Dahlbæk
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_DetectCodec(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{"gzip", []byte{0x1f, 0x8b, 0x08, 0, 0, 0, 0, 0, 0, 0}, "gzip"},
+		{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd, 0, 0, 0, 0, 0, 0}, "zstd"},
+		{"bzip2", []byte("BZh91AY&SY")[:10], "bzip2"},
+		{"plain text", []byte("hello world"), ""},
+		{"repeated byte is not sniffed as brotli", repeatedByte(100), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codec, ok := DetectCodec(tt.head)
+			if tt.want == "" {
+				if ok {
+					t.Errorf("expected no codec detected, got %s", codec.Name())
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("expected codec %s, got none", tt.want)
+			}
+			if codec.Name() != tt.want {
+				t.Errorf("got codec %s, want %s", codec.Name(), tt.want)
+			}
+		})
+	}
+}
+
+func repeatedByte(n int) []byte {
+	return []byte(strings.Repeat("a", n))
+}
+
+func Test_DetectCodec_brotliNeverMatchesByMagic(t *testing.T) {
+	if _, ok := codecByName("brotli"); !ok {
+		t.Fatal("expected brotli to be registered")
+	}
+	for _, c := range codecs {
+		if c.Name() == "brotli" && len(c.Magic()) != 0 {
+			t.Fatal("brotli must have no magic, so DetectCodec never sniffs it")
+		}
+	}
+}
+
+func Test_DetectCodecByMetadata(t *testing.T) {
+	tests := []struct {
+		name            string
+		contentEncoding string
+		contentType     string
+		want            string
+	}{
+		{"gzip content-encoding", "gzip", "", "gzip"},
+		{"br content-encoding maps to brotli", "br", "", "brotli"},
+		{"zstd content-type", "", "application/zstd", "zstd"},
+		{"unrecognized", "identity", "text/plain", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codec, ok := DetectCodecByMetadata(tt.contentEncoding, tt.contentType)
+			if tt.want == "" {
+				if ok {
+					t.Errorf("expected no codec detected, got %s", codec.Name())
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("expected codec %s, got none", tt.want)
+			}
+			if codec.Name() != tt.want {
+				t.Errorf("got codec %s, want %s", codec.Name(), tt.want)
+			}
+		})
+	}
+}
@@ -17,20 +17,23 @@ package lib
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
 	"log"
-	"os"
 	"sync"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/klauspost/compress/zstd"
+	"github.com/gobwas/glob"
 )
 
-func consume(obj Object, s3Client *s3.Client, keys bool) {
+func consume(obj Object, s3Client *s3.Client, args Args, dest Destination, globFreePrefix string, memberPattern glob.Glob) {
+	if (args.Length > 0 || args.Offset > 0) && consumeZstdChunkedRange(obj, s3Client, args, dest, globFreePrefix, args.Keys) {
+		return
+	}
+
 	buffer := manager.NewWriteAtBuffer(make([]byte, obj.Size))
 
 	_, dErr := manager.NewDownloader(s3Client).Download(context.TODO(), buffer, &s3.GetObjectInput{
@@ -41,10 +44,33 @@ func consume(obj Object, s3Client *s3.Client, keys bool) {
 		log.Panicf("failed to download file s3://%s/%s, %v", obj.Bucket, obj.Key, dErr)
 	}
 
-	logContent(obj.Bucket, obj.Key, bytes.NewBuffer(buffer.Bytes()), os.Stdout, keys)
+	// Only fall back to a HeadObject for ContentEncoding/ContentType when
+	// magic sniffing the bytes we already have can't tell us the codec;
+	// an unconditional HeadObject here would double S3 request volume on
+	// the pooled hot path for metadata that's rarely set.
+	var contentEncoding, contentType string
+	head := buffer.Bytes()
+	if need := maxMagicLen(); len(head) > need {
+		head = head[:need]
+	}
+	if _, ok := DetectCodec(head); !ok {
+		if headResp, hErr := s3Client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+			Bucket: &obj.Bucket,
+			Key:    &obj.Key,
+		}); hErr == nil {
+			contentEncoding = aws.ToString(headResp.ContentEncoding)
+			contentType = aws.ToString(headResp.ContentType)
+		}
+	}
+
+	logContent(obj, bytes.NewBuffer(buffer.Bytes()), args, contentEncoding, contentType, dest, globFreePrefix, memberPattern)
 }
 
-func consumeSequential(obj Object, s3Client *s3.Client, keys bool) {
+func consumeSequential(obj Object, s3Client *s3.Client, args Args, dest Destination, globFreePrefix string, memberPattern glob.Glob) {
+	if (args.Length > 0 || args.Offset > 0) && consumeZstdChunkedRange(obj, s3Client, args, dest, globFreePrefix, args.Keys) {
+		return
+	}
+
 	object, err := s3Client.GetObject(context.TODO(), &s3.GetObjectInput{
 		Bucket: &obj.Bucket,
 		Key:    &obj.Key,
@@ -55,54 +81,120 @@ func consumeSequential(obj Object, s3Client *s3.Client, keys bool) {
 	body := object.Body
 	defer body.Close()
 
-	logContent(obj.Bucket, obj.Key, body, os.Stdout, keys)
+	logContent(obj, body, args, aws.ToString(object.ContentEncoding), aws.ToString(object.ContentType), dest, globFreePrefix, memberPattern)
 }
 
-var gzipReader = new(gzip.Reader)
-var zstdReader, _ = zstd.NewReader(nil)
-var peekReader = bufio.NewReaderSize(nil, 4)
-var copyBuf = make([]byte, 1<<20)
+// peekReaderPool and copyBufPool replace the single mutex-guarded peekReader
+// and copyBuf: one per in-flight logContent call instead of one globally,
+// so Args.Concurrency workers actually decode in parallel.
+var peekReaderPool = sync.Pool{
+	New: func() any { return bufio.NewReaderSize(nil, maxMagicLen()) },
+}
 
-var gzipMagic = []byte{0x1f, 0x8b, 0x08}
-var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+var copyBufPool = sync.Pool{
+	New: func() any { buf := make([]byte, 1<<20); return &buf },
+}
 
-var mu sync.Mutex
+// getPeekReader borrows a *bufio.Reader sized at least need bytes, growing
+// (and discarding) a pooled one that's too small for a codec registered
+// since it was created (see maxMagicLen).
+func getPeekReader(body io.Reader, need int) *bufio.Reader {
+	pr := peekReaderPool.Get().(*bufio.Reader)
+	if pr.Size() < need {
+		pr = bufio.NewReaderSize(nil, need)
+	}
+	pr.Reset(body)
+	return pr
+}
 
-func logContent(bucket string, key string, body io.Reader, output io.Writer, keys bool) {
-	mu.Lock()
-	defer mu.Unlock()
+func putPeekReader(pr *bufio.Reader) {
+	pr.Reset(nil)
+	peekReaderPool.Put(pr)
+}
 
-	peekReader.Reset(body)
-	head, err := peekReader.Peek(4)
+// logContent decodes body (an S3 object's bytes) and writes its content to
+// dest. The destination for the object's own key is opened lazily, only
+// once it's clear the whole decoded stream is what gets written there -
+// when Args.Untar expands body into tar members instead, each member gets
+// its own destination via untarTo, and dest is never opened for the
+// archive's own key (opening it unconditionally up front left a spurious
+// empty file/object behind it).
+func logContent(obj Object, body io.Reader, args Args, contentEncoding string, contentType string, dest Destination, globFreePrefix string, memberPattern glob.Glob) {
+	bucket, key := obj.Bucket, obj.Key
+
+	need := maxMagicLen()
+	pr := getPeekReader(body, need)
+	defer putPeekReader(pr)
+
+	head, err := pr.Peek(need)
 	if err != nil && err != io.EOF {
 		log.Fatalf("failed while reading s3://%s/%s: %s", bucket, key, err)
 	}
 
+	// stream is what the eventual codec (if any) reads from; it starts out
+	// as pr, but is rebuilt to replay any bytes consumed probing a
+	// metadata-selected codec that fails to open over the real bytes. A
+	// metadata-selected codec whose magic disagrees with head is skipped
+	// without probing (stale ContentEncoding/ContentType is common in real
+	// data lakes); brotli, which has no magic, is always probed.
+	var stream io.Reader = pr
 	var reader io.Reader
-	switch {
-	case len(head) == 4 && bytes.Equal(head, zstdMagic):
-		err := zstdReader.Reset(peekReader)
-		if err != nil {
-			log.Fatalf("failed to download file s3://%s/%s after zstd verification, %v", bucket, key, err)
+
+	if codec, ok := DetectCodecByMetadata(contentEncoding, contentType); ok && (len(codec.Magic()) == 0 || bytes.HasPrefix(head, codec.Magic())) {
+		var consumed bytes.Buffer
+		decoded, err := codec.NewReader(io.TeeReader(pr, &consumed))
+		if err == nil {
+			defer decoded.Close()
+			reader = decoded
+		} else {
+			stream = io.MultiReader(&consumed, pr)
 		}
-		reader = zstdReader
-	case len(head) >= 3 && bytes.Equal(head[:3], gzipMagic):
-		err := gzipReader.Reset(peekReader)
-		if err != nil {
-			log.Fatalf("failed to download file s3://%s/%s after gzip verification, %v", bucket, key, err)
+	}
+
+	if reader == nil {
+		if codec, ok := DetectCodec(head); ok {
+			decoded, err := codec.NewReader(stream)
+			if err != nil {
+				log.Fatalf("failed to decode s3://%s/%s as %s, %v", bucket, key, codec.Name(), err)
+			}
+			defer decoded.Close()
+			reader = decoded
+		} else {
+			reader = stream
 		}
-		defer gzipReader.Close()
-		reader = gzipReader
-	default:
-		reader = peekReader
 	}
 
-	if keys {
-		fmt.Printf("---------- content of s3://%s/%s ----------\n", bucket, key)
+	if args.Untar {
+		tarPeek := bufio.NewReaderSize(reader, tarMagicOffset+len(tarMagic))
+		if looksLikeTar(tarPeek) {
+			untarTo(bucket, key, tarPeek, args.Keys, dest, obj, globFreePrefix, memberPattern)
+			return
+		}
+		reader = tarPeek
 	}
 
-	nw, err := io.CopyBuffer(output, reader, copyBuf)
+	output, closeOutput := dest.open(obj, globFreePrefix)
+
+	// The banner is terminal decoration, not object content: only emit it
+	// when writing to stdout, and write it through output (the same
+	// lockedWriter-backed stdoutWriter that the content below is written
+	// to) instead of fmt.Printf straight to os.Stdout, so the banner goes
+	// out as one serialized Write. Other goroutines' writes can still land
+	// between the banner and this object's content.
+	if args.Keys && dest.stdout {
+		if _, err := fmt.Fprintf(output, "---------- content of s3://%s/%s ----------\n", bucket, key); err != nil {
+			log.Fatalf("failed while writing s3://%s/%s banner: %s", bucket, key, err)
+		}
+	}
+
+	copyBuf := copyBufPool.Get().(*[]byte)
+	defer copyBufPool.Put(copyBuf)
+
+	nw, err := io.CopyBuffer(output, reader, *copyBuf)
 	if err != nil {
 		log.Fatalf("failed while copying s3://%s/%s to stdout, wrote %d bytes: %s", bucket, key, nw, err)
 	}
+	if err := closeOutput(); err != nil {
+		log.Fatalf("failed to finish writing s3://%s/%s: %v", bucket, key, err)
+	}
 }
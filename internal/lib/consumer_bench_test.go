@@ -0,0 +1,40 @@
+// Copyright 2020 Jonas Dahlbæk
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// BenchmarkLogContent decodes the same gzipped payload repeatedly, run at
+// increasing -cpu values (e.g. `go test -bench=. -cpu=1,2,4,8`) to show
+// throughput scaling with concurrency now that logContent no longer
+// serializes on a package-level mutex.
+func BenchmarkLogContent(b *testing.B) {
+	compressed, err := io.ReadAll(createGzipped(strings.Repeat("cpdash benchmark payload ", 4096)))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			logContent(Object{Bucket: "bucket", Key: "key"}, bytes.NewReader(compressed), Args{}, "", "", Destination{stdout: true, writer: io.Discard}, "", nil)
+		}
+	})
+}
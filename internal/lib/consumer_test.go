@@ -135,7 +135,7 @@ func Test_logContent(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			logContent(tt.args.bucket, tt.args.key, tt.args.body, tt.args.output, tt.args.keys)
+			logContent(Object{Bucket: tt.args.bucket, Key: tt.args.key}, tt.args.body, Args{Keys: tt.args.keys}, "", "", Destination{stdout: true, writer: tt.args.output}, "", nil)
 			output, _ := tt.args.output.(*bytes.Buffer)
 			if output.String() != tt.want {
 				t.Errorf("found %s expected %s", output.String(), tt.want)
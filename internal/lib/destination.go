@@ -0,0 +1,175 @@
+// Copyright 2020 Jonas Dahlbæk
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/zstd"
+)
+
+// lockedWriter serializes Write calls from the concurrent consume workers
+// that all share it, so their decoded output doesn't interleave mid-write.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(p)
+}
+
+// stdoutWriter is the single destination shared by every worker writing to
+// stdout; see lockedWriter.
+var stdoutWriter = &lockedWriter{w: os.Stdout}
+
+// Destination is where consume/consumeSequential write decoded (optionally
+// transcoded) object content: stdout, a local directory, or an S3 prefix.
+// It is resolved once per Run and shared across consumers.
+type Destination struct {
+	stdout      bool
+	localDir    string
+	s3Bucket    string
+	s3Prefix    string
+	s3Client    *s3.Client
+	outputCodec string
+
+	// writer, when set, overrides stdout/local/S3 routing in open; it's a
+	// seam for tests that want to inspect logContent's output directly.
+	// writeMember does not consult it.
+	writer io.Writer
+}
+
+// newDestination resolves Args.Dest into a Destination. An empty string or
+// "-" means stdout, "s3://bucket/prefix" streams through an S3 uploader,
+// and anything else is treated as a local directory.
+func newDestination(dest string, outputCodec string, s3Client *s3.Client) Destination {
+	switch {
+	case dest == "" || dest == "-":
+		return Destination{stdout: true, outputCodec: outputCodec}
+	case strings.HasPrefix(dest, "s3://"):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(dest, "s3://"), "/")
+		return Destination{s3Bucket: bucket, s3Prefix: prefix, s3Client: s3Client, outputCodec: outputCodec}
+	default:
+		return Destination{localDir: dest, outputCodec: outputCodec}
+	}
+}
+
+// relativeKey strips globFreePrefix from key so the destination mirrors the
+// source glob's directory structure underneath Dest.
+func relativeKey(key, globFreePrefix string) string {
+	return strings.TrimPrefix(key, globFreePrefix)
+}
+
+// s3PipeUpload starts a manager.Uploader reading from an io.Pipe in the
+// background and returns the pipe's write end plus a channel the caller
+// receives the upload's final error from once it closes the pipe. It's
+// shared by Destination.open and writeMember (archive.go), both of which
+// stream a body into S3 without buffering it whole.
+func s3PipeUpload(s3Client *s3.Client, bucket, key string) (*io.PipeWriter, <-chan error) {
+	pr, pw := io.Pipe()
+	uploadDone := make(chan error, 1)
+	go func() {
+		_, err := manager.NewUploader(s3Client).Upload(context.TODO(), &s3.PutObjectInput{
+			Bucket: &bucket,
+			Key:    &key,
+			Body:   pr,
+		})
+		uploadDone <- err
+	}()
+	return pw, uploadDone
+}
+
+// open returns a writer for obj's (possibly transcoded) content, and a func
+// that must be called once writing is done to flush the output codec and
+// release whatever open handle or upload backs the destination.
+func (d Destination) open(obj Object, globFreePrefix string) (io.Writer, func() error) {
+	rel := relativeKey(obj.Key, globFreePrefix)
+
+	switch {
+	case d.writer != nil:
+		return d.wrapOutputCodec(d.writer)
+
+	case d.stdout:
+		return d.wrapOutputCodec(stdoutWriter)
+
+	case d.s3Bucket != "":
+		key := strings.TrimPrefix(strings.TrimSuffix(d.s3Prefix, "/")+"/"+rel, "/")
+		pw, uploadDone := s3PipeUpload(d.s3Client, d.s3Bucket, key)
+
+		w, closeCodec := d.wrapOutputCodec(pw)
+		return w, func() error {
+			codecErr := closeCodec()
+			pw.CloseWithError(codecErr)
+			if uploadErr := <-uploadDone; uploadErr != nil {
+				return uploadErr
+			}
+			return codecErr
+		}
+
+	default:
+		path := filepath.Join(d.localDir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			log.Fatalf("failed to create directory for %s: %v", path, err)
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			log.Fatalf("failed to create %s: %v", path, err)
+		}
+
+		w, closeCodec := d.wrapOutputCodec(f)
+		return w, func() error {
+			codecErr := closeCodec()
+			fileErr := f.Close()
+			if codecErr != nil {
+				return codecErr
+			}
+			return fileErr
+		}
+	}
+}
+
+// wrapOutputCodec wraps w so writes to it are transcoded per outputCodec
+// ("none", "gzip", or "zstd"); the returned func flushes and finalizes the
+// encoder, and must be called before w's underlying writer is closed.
+func (d Destination) wrapOutputCodec(w io.Writer) (io.Writer, func() error) {
+	switch d.outputCodec {
+	case "", "none":
+		return w, func() error { return nil }
+	case "gzip":
+		gz := gzip.NewWriter(w)
+		return gz, gz.Close
+	case "zstd":
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			log.Fatalf("failed to create zstd writer: %v", err)
+		}
+		return zw, zw.Close
+	default:
+		log.Fatalf("unknown output codec %q", d.outputCodec)
+		return nil, nil
+	}
+}
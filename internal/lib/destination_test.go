@@ -0,0 +1,67 @@
+// Copyright 2020 Jonas Dahlbæk
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import "testing"
+
+func Test_newDestination(t *testing.T) {
+	tests := []struct {
+		name     string
+		dest     string
+		wantKind string
+		bucket   string
+		prefix   string
+		dir      string
+	}{
+		{"empty is stdout", "", "stdout", "", "", ""},
+		{"dash is stdout", "-", "stdout", "", "", ""},
+		{"s3 uri", "s3://my-bucket/some/prefix", "s3", "my-bucket", "some/prefix", ""},
+		{"s3 uri with no prefix", "s3://my-bucket", "s3", "my-bucket", "", ""},
+		{"local directory", "/tmp/out", "local", "", "", "/tmp/out"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDestination(tt.dest, "none", nil)
+			switch tt.wantKind {
+			case "stdout":
+				if !d.stdout {
+					t.Errorf("expected stdout destination, got %+v", d)
+				}
+			case "s3":
+				if d.s3Bucket != tt.bucket || d.s3Prefix != tt.prefix {
+					t.Errorf("got bucket=%q prefix=%q, want bucket=%q prefix=%q", d.s3Bucket, d.s3Prefix, tt.bucket, tt.prefix)
+				}
+			case "local":
+				if d.localDir != tt.dir {
+					t.Errorf("got localDir=%q, want %q", d.localDir, tt.dir)
+				}
+			}
+		})
+	}
+}
+
+func Test_relativeKey(t *testing.T) {
+	tests := []struct {
+		key, globFreePrefix, want string
+	}{
+		{"logs/2020/01/01/a.gz", "logs/2020/01/01/", "a.gz"},
+		{"logs/2020/01/01/a.gz", "", "logs/2020/01/01/a.gz"},
+	}
+	for _, tt := range tests {
+		if got := relativeKey(tt.key, tt.globFreePrefix); got != tt.want {
+			t.Errorf("relativeKey(%q, %q) = %q, want %q", tt.key, tt.globFreePrefix, got, tt.want)
+		}
+	}
+}
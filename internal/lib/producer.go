@@ -30,12 +30,25 @@ type GlobPattern struct {
 	globFreePrefix string
 	pattern        glob.Glob
 	globs          []glob.Glob
+
+	// memberPattern, when non-nil, matches archive member names for the
+	// "!" extension to the glob syntax (e.g. "*.tar.gz!**/*.json"); see
+	// archive.go.
+	memberPattern glob.Glob
 }
 
 func newGlobPattern(urlArg []string) GlobPattern {
+	full := strings.Join(urlArg[3:], "")
+
+	var memberPattern glob.Glob
+	if idx := strings.Index(full, "!"); idx != -1 {
+		memberPattern = glob.MustCompile(full[idx+1:], '/')
+		full = full[:idx]
+	}
+	dirs := strings.SplitAfter(full, "/")
+
 	prefix := ""
 	globs := []glob.Glob{}
-	dirs := urlArg[3:]
 	globbed := false
 
 	for i, dir := range dirs {
@@ -61,13 +74,12 @@ func newGlobPattern(urlArg []string) GlobPattern {
 		}
 	}
 
-	path := strings.Join(dirs, "")
-
 	return GlobPattern{
 		bucket:         urlArg[2][:len(urlArg[2])-1],
 		globFreePrefix: prefix,
-		pattern:        glob.MustCompile(path, '/'),
+		pattern:        glob.MustCompile(full, '/'),
 		globs:          globs,
+		memberPattern:  memberPattern,
 	}
 }
 
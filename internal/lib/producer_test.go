@@ -0,0 +1,49 @@
+// Copyright 2020 Jonas Dahlbæk
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_newGlobPattern_memberPattern(t *testing.T) {
+	urlArg := strings.SplitAfter("s3://my-bucket/logs/*.tar.gz!**/*.json", "/")
+
+	gp := newGlobPattern(urlArg)
+
+	if gp.memberPattern == nil {
+		t.Fatal("expected a memberPattern to be compiled")
+	}
+	if !gp.memberPattern.Match("a/b/c.json") {
+		t.Error("expected memberPattern to match a/b/c.json")
+	}
+	if gp.memberPattern.Match("a/b/c.txt") {
+		t.Error("expected memberPattern not to match a/b/c.txt")
+	}
+	if !gp.pattern.Match("logs/day.tar.gz") {
+		t.Error("expected the outer pattern to match without the !member suffix")
+	}
+}
+
+func Test_newGlobPattern_noMemberPattern(t *testing.T) {
+	urlArg := strings.SplitAfter("s3://my-bucket/logs/*.gz", "/")
+
+	gp := newGlobPattern(urlArg)
+
+	if gp.memberPattern != nil {
+		t.Error("expected no memberPattern when the URL has no ! suffix")
+	}
+}
@@ -33,6 +33,26 @@ type Args struct {
 	BufferLimit int64
 	Force       bool
 
+	// Offset and Length restrict the content written for each object to a
+	// caller-supplied uncompressed byte range. They are honored only for zstd-chunked
+	// objects (see zstdchunked.go); a zero Length means "to the end".
+	Offset int64
+	Length int64
+
+	// Dest is where decoded content is written: "-" (or empty) for stdout,
+	// an "s3://bucket/prefix" URI, or a local directory. The relative key
+	// path under the source glob's free prefix is preserved underneath it.
+	Dest string
+
+	// OutputCodec transcodes content on the way to Dest: "none" (default),
+	// "gzip", or "zstd".
+	OutputCodec string
+
+	// Untar expands tar/tar.gz/tar.zst objects member-by-member instead of
+	// emitting the raw archive bytes. It's auto-confirmed per object by
+	// peeking for the ustar magic in the decompressed stream.
+	Untar bool
+
 	Cpuprofile string
 	Memprofile string
 
@@ -69,7 +89,16 @@ func Run(args Args) {
 
 	s3Client := s3.NewFromConfig(cfg)
 
-	bytesDownloaded, pooled, streamed := produce(args, s3Client)
+	globPattern := newGlobPattern(args.UrlArg)
+	if globPattern.memberPattern != nil {
+		// The "!member-glob" URL syntax implies archive expansion; without
+		// this, memberPattern is compiled but never consulted since
+		// logContent only looks at it when Untar is set.
+		args.Untar = true
+	}
+	dest := newDestination(args.Dest, args.OutputCodec, s3Client)
+
+	bytesDownloaded, pooled, streamed := produce(args, globPattern, s3Client)
 
 	var wg sync.WaitGroup
 
@@ -77,7 +106,7 @@ func Run(args Args) {
 	go func(streamed <-chan Object) {
 		defer wg.Done()
 		for obj := range streamed {
-			consumeSequential(obj, s3Client, args.Keys)
+			consumeSequential(obj, s3Client, args, dest, globPattern.globFreePrefix, globPattern.memberPattern)
 		}
 	}(streamed)
 
@@ -88,7 +117,7 @@ func Run(args Args) {
 			defer wg.Done()
 			for obj := range pooled {
 				sem.Acquire(context.TODO(), obj.Size)
-				consume(obj, s3Client, args.Keys)
+				consume(obj, s3Client, args, dest, globPattern.globFreePrefix, globPattern.memberPattern)
 				sem.Release(obj.Size)
 			}
 		}(pooled)
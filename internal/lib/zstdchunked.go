@@ -0,0 +1,249 @@
+// Copyright 2020 Jonas Dahlbæk
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/sync/semaphore"
+)
+
+// zstdChunkedMagic is the little-endian wire encoding of the skippable frame
+// magic number (0x184D2A5E) reserved for the zstd-chunked table-of-contents
+// frame appended to the end of a zstd-chunked object.
+var zstdChunkedMagic = []byte{0x5e, 0x2a, 0x4d, 0x18}
+
+// zstdChunkedTailSize is how much of the tail of an object is fetched in a
+// single ranged GET to locate and parse the table-of-contents. The TOC is
+// small relative to the data it indexes, so this comfortably covers it in
+// one round trip for any object cpdash is likely to see.
+const zstdChunkedTailSize = 64 * 1024
+
+// zstdChunk is one table-of-contents entry: the uncompressed byte offset a
+// chunk starts at, and where its independently decodable zstd frame lives in
+// the compressed object.
+type zstdChunk struct {
+	UncompressedOffset int64
+	CompressedOffset   int64
+	CompressedLen      int64
+}
+
+// parseZstdChunkedTOC scans tail (taken from the end of an object) for a
+// trailing zstd skippable frame carrying a table-of-contents and decodes it.
+// It returns ok=false when no such frame is present, so callers can fall
+// back to full-stream decoding.
+func parseZstdChunkedTOC(tail []byte) (chunks []zstdChunk, ok bool) {
+	const entrySize = 24
+	for start := len(tail) - 8; start >= 0; start-- {
+		if !bytes.Equal(tail[start:start+4], zstdChunkedMagic) {
+			continue
+		}
+		frameSize := int64(binary.LittleEndian.Uint32(tail[start+4 : start+8]))
+		payload := tail[start+8:]
+		if frameSize <= 0 || frameSize%entrySize != 0 || int64(len(payload)) < frameSize {
+			continue
+		}
+		payload = payload[:frameSize]
+		chunks = make([]zstdChunk, 0, frameSize/entrySize)
+		for i := int64(0); i < frameSize; i += entrySize {
+			chunks = append(chunks, zstdChunk{
+				UncompressedOffset: int64(binary.LittleEndian.Uint64(payload[i : i+8])),
+				CompressedOffset:   int64(binary.LittleEndian.Uint64(payload[i+8 : i+16])),
+				CompressedLen:      int64(binary.LittleEndian.Uint64(payload[i+16 : i+24])),
+			})
+		}
+		return chunks, true
+	}
+	return nil, false
+}
+
+// fetchZstdChunkedTOC issues a tail range GET for the last zstdChunkedTailSize
+// bytes of the object and tries to parse a table-of-contents out of it.
+func fetchZstdChunkedTOC(s3Client *s3.Client, bucket, key string, size int64) ([]zstdChunk, bool) {
+	start := size - zstdChunkedTailSize
+	if start < 0 {
+		start = 0
+	}
+	rng := fmt.Sprintf("bytes=%d-%d", start, size-1)
+	out, err := s3Client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+		Range:  &rng,
+	})
+	if err != nil {
+		return nil, false
+	}
+	defer out.Body.Close()
+
+	tail, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, false
+	}
+	return parseZstdChunkedTOC(tail)
+}
+
+// chunksCovering returns the minimal subsequence of chunks (sorted by
+// UncompressedOffset, as written to the TOC) whose decoded bytes overlap
+// [offset, offset+length). A length of zero means "to the end of the
+// object".
+func chunksCovering(chunks []zstdChunk, offset, length int64) []zstdChunk {
+	end := int64(math.MaxInt64)
+	if length > 0 {
+		end = offset + length
+	}
+
+	var covering []zstdChunk
+	for i, chunk := range chunks {
+		chunkEnd := int64(math.MaxInt64)
+		if i+1 < len(chunks) {
+			chunkEnd = chunks[i+1].UncompressedOffset
+		}
+		if chunkEnd <= offset || chunk.UncompressedOffset >= end {
+			continue
+		}
+		covering = append(covering, chunk)
+	}
+	return covering
+}
+
+// consumeZstdChunkedRange serves args.Offset/args.Length of obj by fetching
+// only the covering chunks of a zstd-chunked object with parallel ranged S3
+// GETs and decoding each chunk through its own zstd.Decoder, rather than
+// streaming the whole object through logContent. Concurrent chunk fetches
+// are capped by a semaphore of args.BufferLimit bytes created per call,
+// separate from the one Run's pooled workers share, so a chunk-heavy range
+// read can't fan out unbounded goroutines and buffers. It returns false, having written nothing and never having
+// opened dest, when obj isn't zstd-chunked (or the TOC can't be found) so
+// the caller can fall back to the ordinary full-stream path without a
+// spurious empty write to dest first.
+func consumeZstdChunkedRange(obj Object, s3Client *s3.Client, args Args, dest Destination, globFreePrefix string, keys bool) bool {
+	chunks, ok := fetchZstdChunkedTOC(s3Client, obj.Bucket, obj.Key, obj.Size)
+	if !ok {
+		return false
+	}
+	covering := chunksCovering(chunks, args.Offset, args.Length)
+	if len(covering) == 0 {
+		return false
+	}
+
+	output, closeOutput := dest.open(obj, globFreePrefix)
+
+	decoded := make([][]byte, len(covering))
+	sem := semaphore.NewWeighted(args.BufferLimit)
+	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var firstErr error
+	fail := func(err error) {
+		errMu.Lock()
+		defer errMu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for i, chunk := range covering {
+		wg.Add(1)
+		go func(i int, chunk zstdChunk) {
+			defer wg.Done()
+
+			// Acquire never requests more than the semaphore's own size: a
+			// chunk larger than args.BufferLimit would otherwise block
+			// forever (Weighted.Acquire waits on a context that never
+			// completes when n > size). Such a chunk still has to be
+			// fetched, so it's treated as consuming the whole budget
+			// instead, which simply serializes it with everything else.
+			weight := chunk.CompressedLen
+			if weight > args.BufferLimit {
+				weight = args.BufferLimit
+			}
+			if err := sem.Acquire(context.TODO(), weight); err != nil {
+				fail(fmt.Errorf("acquiring buffer budget for chunk at uncompressed offset %d: %w", chunk.UncompressedOffset, err))
+				return
+			}
+			defer sem.Release(weight)
+
+			compressed := manager.NewWriteAtBuffer(make([]byte, chunk.CompressedLen))
+			rng := fmt.Sprintf("bytes=%d-%d", chunk.CompressedOffset, chunk.CompressedOffset+chunk.CompressedLen-1)
+			_, err := manager.NewDownloader(s3Client).Download(context.TODO(), compressed, &s3.GetObjectInput{
+				Bucket: &obj.Bucket,
+				Key:    &obj.Key,
+				Range:  &rng,
+			})
+			if err != nil {
+				fail(fmt.Errorf("fetching chunk at uncompressed offset %d: %w", chunk.UncompressedOffset, err))
+				return
+			}
+
+			dec, err := zstd.NewReader(bytes.NewReader(compressed.Bytes()))
+			if err != nil {
+				fail(fmt.Errorf("opening chunk at uncompressed offset %d: %w", chunk.UncompressedOffset, err))
+				return
+			}
+			defer dec.Close()
+
+			decoded[i], err = io.ReadAll(dec)
+			if err != nil {
+				fail(fmt.Errorf("decoding chunk at uncompressed offset %d: %w", chunk.UncompressedOffset, err))
+			}
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		log.Fatalf("failed to serve ranged content of s3://%s/%s: %v", obj.Bucket, obj.Key, firstErr)
+	}
+
+	// The banner is terminal decoration, not object content: only emit it
+	// when writing to stdout, and write it through output (the same
+	// lockedWriter-backed stdoutWriter that content is written to below)
+	// instead of fmt.Printf straight to os.Stdout, so it can't interleave
+	// with another goroutine's concurrent write to the same destination.
+	if keys && dest.stdout {
+		if _, err := fmt.Fprintf(output, "---------- content of s3://%s/%s ----------\n", obj.Bucket, obj.Key); err != nil {
+			log.Fatalf("failed while writing s3://%s/%s banner: %v", obj.Bucket, obj.Key, err)
+		}
+	}
+
+	end := int64(math.MaxInt64)
+	if args.Length > 0 {
+		end = args.Offset + args.Length
+	}
+	for i, chunk := range covering {
+		lo, hi := int64(0), int64(len(decoded[i]))
+		if chunk.UncompressedOffset < args.Offset {
+			lo = args.Offset - chunk.UncompressedOffset
+		}
+		if chunkEnd := chunk.UncompressedOffset + int64(len(decoded[i])); chunkEnd > end {
+			hi -= chunkEnd - end
+		}
+		if _, err := output.Write(decoded[i][lo:hi]); err != nil {
+			log.Fatalf("failed while writing ranged content of s3://%s/%s: %v", obj.Bucket, obj.Key, err)
+		}
+	}
+	if err := closeOutput(); err != nil {
+		log.Fatalf("failed to finish writing s3://%s/%s: %v", obj.Bucket, obj.Key, err)
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 Jonas Dahlbæk
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func encodeTOC(chunks []zstdChunk) []byte {
+	var payload bytes.Buffer
+	for _, c := range chunks {
+		var entry [24]byte
+		binary.LittleEndian.PutUint64(entry[0:8], uint64(c.UncompressedOffset))
+		binary.LittleEndian.PutUint64(entry[8:16], uint64(c.CompressedOffset))
+		binary.LittleEndian.PutUint64(entry[16:24], uint64(c.CompressedLen))
+		payload.Write(entry[:])
+	}
+
+	var frame bytes.Buffer
+	frame.Write(zstdChunkedMagic)
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(payload.Len()))
+	frame.Write(size[:])
+	frame.Write(payload.Bytes())
+	return frame.Bytes()
+}
+
+func Test_parseZstdChunkedTOC(t *testing.T) {
+	want := []zstdChunk{
+		{UncompressedOffset: 0, CompressedOffset: 0, CompressedLen: 100},
+		{UncompressedOffset: 1000, CompressedOffset: 100, CompressedLen: 120},
+	}
+
+	tail := append([]byte("leading compressed bytes go here"), encodeTOC(want)...)
+
+	got, ok := parseZstdChunkedTOC(tail)
+	if !ok {
+		t.Fatal("expected a table-of-contents to be found")
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d chunks, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("chunk %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func Test_parseZstdChunkedTOC_notChunked(t *testing.T) {
+	if _, ok := parseZstdChunkedTOC([]byte("just some ordinary zstd frame bytes")); ok {
+		t.Error("expected no table-of-contents to be found")
+	}
+}
+
+func Test_chunksCovering(t *testing.T) {
+	chunks := []zstdChunk{
+		{UncompressedOffset: 0},
+		{UncompressedOffset: 100},
+		{UncompressedOffset: 200},
+		{UncompressedOffset: 300},
+	}
+
+	tests := []struct {
+		name           string
+		offset, length int64
+		wantOffsets    []int64
+	}{
+		{"within one chunk", 110, 10, []int64{100}},
+		{"spanning two chunks", 90, 30, []int64{0, 100}},
+		{"zero length reads to the end", 250, 0, []int64{200, 300}},
+		{"starts exactly on a boundary", 200, 50, []int64{200}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := chunksCovering(chunks, tt.offset, tt.length)
+			if len(got) != len(tt.wantOffsets) {
+				t.Fatalf("got %d chunks, want %d", len(got), len(tt.wantOffsets))
+			}
+			for i, want := range tt.wantOffsets {
+				if got[i].UncompressedOffset != want {
+					t.Errorf("chunk %d: got offset %d, want %d", i, got[i].UncompressedOffset, want)
+				}
+			}
+		})
+	}
+}